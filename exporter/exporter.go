@@ -0,0 +1,152 @@
+// Copyright (c) 2015 NATS Messaging System
+
+// Package exporter exposes the same stats that the interactive UI shows
+// as Prometheus text-format metrics, so nats-top can double as a scrape
+// target for an existing Prometheus/Grafana stack.
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	. "github.com/nats-io/nats-top/util"
+)
+
+// Exporter serves the most recently polled *Stats as Prometheus metrics.
+type Exporter struct {
+	Addr string
+
+	mu    sync.Mutex
+	stats *Stats
+}
+
+// NewExporter creates an Exporter that will listen on addr once Run is
+// called.
+func NewExporter(addr string) *Exporter {
+	return &Exporter{Addr: addr}
+}
+
+// Update records the latest sample to be served on the next scrape.
+func (ex *Exporter) Update(stats *Stats) {
+	ex.mu.Lock()
+	ex.stats = stats
+	ex.mu.Unlock()
+}
+
+// Run starts polling statsCh for new samples and blocks serving /metrics
+// over HTTP until shutdownCh is closed.
+func (ex *Exporter) Run(statsCh chan *Stats, shutdownCh chan struct{}) error {
+	go func() {
+		for {
+			select {
+			case stats := <-statsCh:
+				ex.Update(stats)
+			case <-shutdownCh:
+				return
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ex.ServeMetrics)
+
+	server := &http.Server{Addr: ex.Addr, Handler: mux}
+
+	go func() {
+		<-shutdownCh
+		server.Close()
+	}()
+
+	return server.ListenAndServe()
+}
+
+// ServeMetrics writes the latest sample in Prometheus text exposition
+// format.
+func (ex *Exporter) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	ex.mu.Lock()
+	stats := ex.stats
+	ex.mu.Unlock()
+
+	if stats == nil || stats.Error != nil {
+		http.Error(w, "no stats available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeMetrics(w, stats)
+}
+
+func writeMetrics(w io.Writer, stats *Stats) {
+	varz := stats.Varz
+
+	gauge(w, "gnatsd_cpu", "Current CPU usage, as a percentage.", float64(varz.CPU))
+	gauge(w, "gnatsd_mem_bytes", "Current resident memory usage in bytes.", float64(varz.Mem))
+	gauge(w, "gnatsd_slow_consumers", "Total number of slow consumers detected.", float64(varz.SlowConsumers))
+	counter(w, "gnatsd_in_msgs_total", "Total number of messages received by the server.", float64(varz.InMsgs))
+	counter(w, "gnatsd_out_msgs_total", "Total number of messages sent by the server.", float64(varz.OutMsgs))
+	counter(w, "gnatsd_in_bytes_total", "Total number of bytes received by the server.", float64(varz.InBytes))
+	counter(w, "gnatsd_out_bytes_total", "Total number of bytes sent by the server.", float64(varz.OutBytes))
+
+	writeConnGauges(w, stats)
+}
+
+var connGauges = []struct {
+	name string
+	help string
+	val  func(conn *gnatsd.ConnInfo) float64
+}{
+	{"gnatsd_conn_pending_bytes", "Bytes pending to be flushed to the connection.",
+		func(c *gnatsd.ConnInfo) float64 { return float64(c.Pending) }},
+	{"gnatsd_conn_in_msgs", "Messages received from this connection.",
+		func(c *gnatsd.ConnInfo) float64 { return float64(c.InMsgs) }},
+	{"gnatsd_conn_out_msgs", "Messages sent to this connection.",
+		func(c *gnatsd.ConnInfo) float64 { return float64(c.OutMsgs) }},
+	{"gnatsd_conn_in_bytes", "Bytes received from this connection.",
+		func(c *gnatsd.ConnInfo) float64 { return float64(c.InBytes) }},
+	{"gnatsd_conn_out_bytes", "Bytes sent to this connection.",
+		func(c *gnatsd.ConnInfo) float64 { return float64(c.OutBytes) }},
+}
+
+func writeConnGauges(w io.Writer, stats *Stats) {
+	conns := stats.Connz.Conns
+
+	// Sort by CID so the output is stable between scrapes.
+	sort.Sort(ByCid(conns))
+
+	for _, g := range connGauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		for _, conn := range conns {
+			fmt.Fprintf(w, "%s{cid=\"%d\",name=\"%s\",lang=\"%s\"} %v\n",
+				g.name, conn.Cid, escapeLabelValue(conn.Name), escapeLabelValue(conn.Lang), g.val(conn))
+		}
+	}
+}
+
+// escapeLabelValue escapes a string for use inside a Prometheus label
+// value, as required by the text exposition format: backslash and
+// double-quote are backslash-escaped, and newlines become \n. conn.Name
+// and conn.Lang come from the client's CONNECT options, so they can't be
+// trusted to already be scrape-safe.
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return s
+}
+
+func gauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+func counter(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
@@ -4,6 +4,8 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -15,6 +17,7 @@ import (
 	"time"
 
 	gnatsd "github.com/nats-io/gnatsd/server"
+	"github.com/nats-io/nats-top/exporter"
 	. "github.com/nats-io/nats-top/util"
 	ui "gopkg.in/gizak/termui.v1"
 )
@@ -22,12 +25,23 @@ import (
 const version = "0.2.0"
 
 var (
-	host        = flag.String("s", "127.0.0.1", "The nats server host.")
+	host        = flag.String("s", "127.0.0.1", "The nats server host(s). Comma-separate host:port pairs to monitor a cluster.")
 	port        = flag.Int("m", 8222, "The NATS server monitoring port.")
 	conns       = flag.Int("n", 1024, "Maximum number of connections to poll.")
 	delay       = flag.Int("d", 1, "Refresh interval in seconds.")
 	sortBy      = flag.String("sort", "cid", "Value for which to sort by the connections.")
 	showVersion = flag.Bool("v", false, "Show nats-top version.")
+	cidFilter   = flag.Uint64("cid", 0, "Filter connections to a single CID.")
+	stateFilter = flag.String("state", "", "Filter connections by state: open, closed or any.")
+	exposeAddr  = flag.String("expose", "", "Run headless and expose Prometheus metrics on this address (e.g. :7777).")
+	history     = flag.Int("history", 0, "Number of samples of rate history to retain for sparklines (0 disables).")
+	recordFile  = flag.String("record", "", "Record every polled sample as newline-delimited JSON to this file.")
+	replayFile  = flag.String("replay", "", "Replay a file written with -record instead of polling a live server.")
+	replaySpeed = flag.Float64("speed", 1.0, "Replay speed multiplier, used with -replay.")
+	sinceOpt    = flag.String("since", "", "Only replay samples at or after this RFC3339 time, used with -replay.")
+	untilOpt    = flag.String("until", "", "Only replay samples at or before this RFC3339 time, used with -replay.")
+	batchCount  = flag.Int("batch", 0, "Poll this many samples non-interactively, write them to stdout, and exit.")
+	formatOpt   = flag.String("format", "table", "Output format for -batch: table, csv or json.")
 
 	// Secure options
 	httpsPort     = flag.Int("ms", 0, "The NATS server secure monitoring port.")
@@ -39,6 +53,9 @@ var (
 
 var usageHelp = `
 usage: nats-top [-s server] [-m http_port] [-ms https_port] [-n num_connections] [-d delay_secs] [-sort by]
+                [-cid cid] [-state open|closed|any] [-expose host:port] [-history num_samples]
+                [-record file.jsonl | -replay file.jsonl [-speed mult] [-since time] [-until time]]
+                [-batch num_samples [-format table|csv|json]]
                 [-cert FILE] [-key FILE ][-cacert FILE] [-k]
 `
 
@@ -59,11 +76,153 @@ func main() {
 		os.Exit(0)
 	}
 
-	engine := &Engine{}
-	engine.Conns = *conns
-	engine.Delay = *delay
+	if *host == "" {
+		log.Fatalf("Please specify the monitoring endpoint for NATS.\n")
+		usage()
+	}
+
+	if *port == 0 && *httpsPort == 0 {
+		log.Fatalf("Please specify the monitoring port for NATS.\n")
+		usage()
+	}
+
+	sortOpt := gnatsd.SortOpt(*sortBy)
+	switch sortOpt {
+	case SortByCid, SortBySubs, SortByPending, SortByOutMsgs, SortByInMsgs, SortByOutBytes, SortByInBytes, SortByMsgsRate:
+	default:
+		log.Printf("nats-top: not a valid option to sort by: %s\n", sortOpt)
+		usage()
+	}
+
+	switch *stateFilter {
+	case "", "open", "closed", "any":
+	default:
+		log.Printf("nats-top: not a valid option for state: %s\n", *stateFilter)
+		usage()
+	}
+
+	if *recordFile != "" && *replayFile != "" {
+		log.Printf("nats-top: -record and -replay are mutually exclusive\n")
+		usage()
+	}
+
+	switch *formatOpt {
+	case "table", "csv", "json":
+	default:
+		log.Printf("nats-top: not a valid option for format: %s\n", *formatOpt)
+		usage()
+	}
+
+	cluster := buildCluster(sortOpt)
+
+	if *batchCount > 0 {
+		if err := runBatch(cluster, *batchCount, *formatOpt); err != nil {
+			log.Fatalf("nats-top: batch run failed: %s", err)
+		}
+		return
+	}
+
+	statsCh := make(chan *ServerStats)
+	shutdownCh := make(chan struct{})
+
+	if *exposeAddr != "" {
+		// Headless mode only scrapes the first configured server; the
+		// interactive cluster view is what fans out to all of them.
+		if len(cluster.Names) > 1 {
+			log.Printf("nats-top: -expose only scrapes %s; cluster mode serves one server at a time", cluster.Names[0])
+		}
+		singleCh := make(chan *Stats)
+		go cluster.Engines[0].MonitorStats(singleCh, shutdownCh)
+		ex := exporter.NewExporter(*exposeAddr)
+		log.Printf("nats-top: exposing metrics on %s/metrics", *exposeAddr)
+		log.Fatal(ex.Run(singleCh, shutdownCh))
+		return
+	}
+
+	err := ui.Init()
+	if err != nil {
+		panic(err)
+	}
+	defer ui.Close()
+
+	var player *Player
+	stepCh := make(chan struct{}, 1)
+
+	switch {
+	case *replayFile != "":
+		f, ferr := os.Open(*replayFile)
+		if ferr != nil {
+			log.Fatalf("nats-top: could not open replay file: %s", ferr)
+		}
+		defer f.Close()
+
+		opts := ReplayOptions{Speed: *replaySpeed}
+		if *sinceOpt != "" {
+			opts.Since, err = time.Parse(time.RFC3339, *sinceOpt)
+			if err != nil {
+				log.Fatalf("nats-top: invalid -since time: %s", err)
+			}
+		}
+		if *untilOpt != "" {
+			opts.Until, err = time.Parse(time.RFC3339, *untilOpt)
+			if err != nil {
+				log.Fatalf("nats-top: invalid -until time: %s", err)
+			}
+		}
+
+		player = &Player{}
+		go func() {
+			if err := ReplayStats(f, opts, statsCh, shutdownCh, player, stepCh); err != nil {
+				log.Printf("nats-top: replay error: %s", err)
+			}
+		}()
+
+	case *recordFile != "":
+		f, ferr := os.Create(*recordFile)
+		if ferr != nil {
+			log.Fatalf("nats-top: could not create record file: %s", ferr)
+		}
+		defer f.Close()
+
+		// Tee the live stream: one copy renders in the UI, the other is
+		// persisted to disk for a later -replay.
+		liveCh := make(chan *ServerStats)
+		recordCh := make(chan *ServerStats)
+		cluster.MonitorStats(liveCh, shutdownCh)
+
+		go func() {
+			for {
+				select {
+				case stats := <-liveCh:
+					recordCh <- stats
+					statsCh <- stats
+				case <-shutdownCh:
+					return
+				}
+			}
+		}()
+
+		go func() {
+			if err := RecordStats(f, recordCh, shutdownCh); err != nil {
+				log.Printf("nats-top: could not record samples: %s", err)
+			}
+		}()
+
+	default:
+		cluster.MonitorStats(statsCh, shutdownCh)
+	}
+
+	StartUI(cluster, statsCh, shutdownCh, player, stepCh)
+}
+
+// buildCluster turns the -s flag (a single host or a comma-separated
+// list of host:port pairs) into a Cluster with one Engine per server,
+// all sharing the same connection, sort and filter settings.
+func buildCluster(sortOpt gnatsd.SortOpt) *Cluster {
+	var httpClient *http.Client
+	scheme := "http"
+	defaultPort := *port
 
-	// Use secure port if set explicitly, otherwise use http port by default
 	if *httpsPort != 0 {
 		tlsConfig := &tls.Config{}
 		if *caCertOpt != "" {
@@ -91,43 +250,138 @@ func main() {
 		}
 
 		tlsConfig.BuildNameToCertificate()
-		transport := &http.Transport{TLSClientConfig: tlsConfig}
-		engine.HttpClient = &http.Client{Transport: transport}
-		engine.Uri = fmt.Sprintf("https://%s:%d", *host, *httpsPort)
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		scheme = "https"
+		defaultPort = *httpsPort
 	} else {
-		engine.HttpClient = &http.Client{}
-		engine.Uri = fmt.Sprintf("http://%s:%d", *host, *port)
+		httpClient = &http.Client{}
 	}
 
-	if *host == "" {
-		log.Fatalf("Please specify the monitoring endpoint for NATS.\n")
-		usage()
+	cluster := &Cluster{}
+
+	for _, server := range strings.Split(*host, ",") {
+		server = strings.TrimSpace(server)
+		serverHost, serverPort := server, defaultPort
+
+		if i := strings.LastIndex(server, ":"); i != -1 {
+			serverHost = server[:i]
+			fmt.Sscanf(server[i+1:], "%d", &serverPort)
+		}
+
+		name := fmt.Sprintf("%s:%d", serverHost, serverPort)
+
+		engine := &Engine{}
+		engine.Conns = *conns
+		engine.Delay = *delay
+		engine.Cid = *cidFilter
+		engine.State = *stateFilter
+		engine.SortOpt = sortOpt
+		engine.History = *history
+		engine.HttpClient = httpClient
+		engine.Uri = fmt.Sprintf("%s://%s:%d", scheme, serverHost, serverPort)
+
+		cluster.Engines = append(cluster.Engines, engine)
+		cluster.Names = append(cluster.Names, name)
 	}
 
-	if *port == 0 && *httpsPort == 0 {
-		log.Fatalf("Please specify the monitoring port for NATS.\n")
-		usage()
+	return cluster
+}
+
+// runBatch polls the cluster n times without starting termui, writing
+// each sample to stdout in the requested format, then returns. It's
+// meant for scripting nats-top from cron/ansible or piping into
+// awk/jq where a TTY isn't available.
+func runBatch(cluster *Cluster, n int, format string) error {
+	statsCh := make(chan *ServerStats)
+	shutdownCh := make(chan struct{})
+	defer close(shutdownCh)
+
+	cluster.MonitorStats(statsCh, shutdownCh)
+
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		csvWriter = csv.NewWriter(os.Stdout)
+		if err := csvWriter.Write(batchCSVHeader); err != nil {
+			return err
+		}
 	}
 
-	sortOpt := gnatsd.SortOpt(*sortBy)
-	switch sortOpt {
-	case SortByCid, SortBySubs, SortByPending, SortByOutMsgs, SortByInMsgs, SortByOutBytes, SortByInBytes:
-		engine.SortOpt = sortOpt
-	default:
-		log.Printf("nats-top: not a valid option to sort by: %s\n", sortOpt)
-		usage()
+	for i := 0; i < n; i++ {
+		serverStats := <-statsCh
+
+		switch format {
+		case "json":
+			if err := json.NewEncoder(os.Stdout).Encode(serverStats); err != nil {
+				return err
+			}
+		case "csv":
+			if err := writeBatchCSV(csvWriter, serverStats); err != nil {
+				return err
+			}
+		default:
+			engine := engineForServer(cluster, serverStats.Name)
+			fmt.Printf("Server [%s] - sample %d/%d\n\n", serverStats.Name, i+1, n)
+			fmt.Println(generateParagraph(engine, serverStats.Stats))
+		}
 	}
 
-	err := ui.Init()
-	if err != nil {
-		panic(err)
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
 	}
-	defer ui.Close()
 
-	statsCh := make(chan *Stats)
-	shutdownCh := make(chan struct{})
-	go engine.MonitorStats(statsCh, shutdownCh)
-	StartUI(engine, statsCh, shutdownCh)
+	return nil
+}
+
+// engineForServer looks up the Engine configured for a given server
+// name, so batch output can reuse the same rendering code as the
+// interactive UI.
+func engineForServer(cluster *Cluster, name string) *Engine {
+	for i, n := range cluster.Names {
+		if n == name {
+			return cluster.Engines[i]
+		}
+	}
+	return nil
+}
+
+// batchCSVHeader is the column order written by writeBatchCSV.
+var batchCSVHeader = []string{
+	"server", "cid", "name", "ip", "port", "subs", "pending",
+	"msgs_to", "msgs_from", "bytes_to", "bytes_from",
+	"lang", "version", "uptime", "last_activity",
+}
+
+// writeBatchCSV appends one CSV row per connection in stats to w.
+func writeBatchCSV(w *csv.Writer, stats *ServerStats) error {
+	if stats.Stats == nil || stats.Stats.Connz == nil {
+		return nil
+	}
+
+	for _, conn := range stats.Stats.Connz.Conns {
+		row := []string{
+			stats.Name,
+			fmt.Sprintf("%d", conn.Cid),
+			conn.Name,
+			conn.IP,
+			fmt.Sprintf("%d", conn.Port),
+			fmt.Sprintf("%d", conn.NumSubs),
+			fmt.Sprintf("%d", conn.Pending),
+			fmt.Sprintf("%d", conn.OutMsgs),
+			fmt.Sprintf("%d", conn.InMsgs),
+			fmt.Sprintf("%d", conn.OutBytes),
+			fmt.Sprintf("%d", conn.InBytes),
+			conn.Lang,
+			conn.Version,
+			conn.Uptime,
+			conn.LastActivity.Format(time.RFC3339),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // clearScreen tries to ensure resetting original state of screen
@@ -156,6 +410,13 @@ func generateParagraph(
 	stats *Stats,
 ) string {
 
+	if stats == nil || stats.Error != nil || stats.Varz == nil || stats.Connz == nil {
+		if stats != nil && stats.Error != nil {
+			return fmt.Sprintf("Error fetching stats: %s", stats.Error)
+		}
+		return "Waiting for stats from server..."
+	}
+
 	// Snapshot current stats
 	cpu := stats.Varz.CPU
 	memVal := stats.Varz.Mem
@@ -234,6 +495,8 @@ func generateParagraph(
 		sort.Sort(sort.Reverse(ByBytesTo(stats.Connz.Conns)))
 	case SortByInBytes:
 		sort.Sort(sort.Reverse(ByBytesFrom(stats.Connz.Conns)))
+	case SortByMsgsRate:
+		sort.Sort(sort.Reverse(ByMsgsRate{Conns: stats.Connz.Conns, Rates: stats.ConnRates}))
 	}
 
 	for _, conn := range stats.Connz.Conns {
@@ -257,6 +520,53 @@ func generateParagraph(
 	return text
 }
 
+// generateClusterParagraph renders a summary across every server in the
+// cluster: one subtotal row per server plus a global totals row, for
+// the 'a' aggregated view. CPU is averaged rather than summed, since a
+// percentage doesn't aggregate the way a count or byte total does;
+// every other column is a straight sum across the servers reporting.
+func generateClusterParagraph(cluster *Cluster, statsMap map[string]*Stats) string {
+	header := "  %-24s %8s %8s %10s %12s %12s %12s %12s\n"
+	row := "  %-24s %8d %7.1f%% %10s %12s %12s %12s %12s\n"
+
+	text := fmt.Sprintf(header, "SERVER", "CONNS", "CPU", "MEM",
+		"IN MSGS", "OUT MSGS", "IN BYTES", "OUT BYTES")
+
+	var totalConns, reporting int
+	var totalCPU float64
+	var totalMem, totalInMsgs, totalOutMsgs, totalInBytes, totalOutBytes int64
+
+	for _, name := range cluster.Names {
+		stats, ok := statsMap[name]
+		if !ok || stats.Varz == nil || stats.Error != nil {
+			text += fmt.Sprintf("  %-24s %8s\n", name, "-")
+			continue
+		}
+
+		text += fmt.Sprintf(row, name, stats.Connz.NumConns, stats.Varz.CPU, Psize(stats.Varz.Mem),
+			Psize(stats.Varz.InMsgs), Psize(stats.Varz.OutMsgs), Psize(stats.Varz.InBytes), Psize(stats.Varz.OutBytes))
+
+		totalConns += stats.Connz.NumConns
+		totalCPU += stats.Varz.CPU
+		reporting++
+		totalMem += stats.Varz.Mem
+		totalInMsgs += stats.Varz.InMsgs
+		totalOutMsgs += stats.Varz.OutMsgs
+		totalInBytes += stats.Varz.InBytes
+		totalOutBytes += stats.Varz.OutBytes
+	}
+
+	var avgCPU float64
+	if reporting > 0 {
+		avgCPU = totalCPU / float64(reporting)
+	}
+
+	text += fmt.Sprintf(row, "TOTAL", totalConns, avgCPU, Psize(totalMem),
+		Psize(totalInMsgs), Psize(totalOutMsgs), Psize(totalInBytes), Psize(totalOutBytes))
+
+	return text
+}
+
 type ViewMode int
 
 const (
@@ -266,9 +576,11 @@ const (
 
 // StartUI periodically refreshes the screen using recent data.
 func StartUI(
-	engine *Engine,
-	statsCh chan *Stats,
+	cluster *Cluster,
+	statsCh chan *ServerStats,
 	shutdownCh chan struct{},
+	player *Player,
+	stepCh chan struct{},
 ) {
 
 	cleanStats := &Stats{
@@ -277,8 +589,70 @@ func StartUI(
 		Rates: &Rates{},
 	}
 
+	// Latest sample received from each server, keyed by name.
+	statsMap := make(map[string]*Stats, len(cluster.Names))
+
+	// Index into cluster.Names/Engines of the server currently focused
+	// in per-server mode.
+	focus := 0
+
+	// When true, render the aggregated cluster summary instead of a
+	// single server's connection table.
+	aggregate := len(cluster.Names) > 1
+
+	focusedEngine := func() *Engine { return cluster.Engines[focus] }
+
+	// historyEnabled is true when -history was given, in which case a
+	// sparklines panel is shown above the connection table for the
+	// focused server.
+	historyEnabled := focusedEngine().History > 0
+
+	render := func() string {
+		if aggregate {
+			return generateClusterParagraph(cluster, statsMap)
+		}
+
+		name := cluster.Names[focus]
+		stats, ok := statsMap[name]
+		if !ok {
+			stats = cleanStats
+		}
+
+		header := fmt.Sprintf("Server [%d/%d]: %s\n\n", focus+1, len(cluster.Names), name)
+		return header + generateParagraph(focusedEngine(), stats)
+	}
+
+	inRateSpark := ui.NewSparkline()
+	inRateSpark.Title = "In Msgs/Sec"
+	inRateSpark.Height = 2
+	inRateSpark.LineColor = ui.ColorCyan
+
+	outRateSpark := ui.NewSparkline()
+	outRateSpark.Title = "Out Msgs/Sec"
+	outRateSpark.Height = 2
+	outRateSpark.LineColor = ui.ColorGreen
+
+	sparklines := ui.NewSparklines(inRateSpark, outRateSpark)
+	sparklines.Height = 8
+	sparklines.Width = ui.TermWidth()
+	sparklines.Border.Label = "Rate history"
+
+	refreshSparklines := func() {
+		if !historyEnabled || aggregate {
+			return
+		}
+
+		stats, ok := statsMap[cluster.Names[focus]]
+		if !ok || stats.RateHistory == nil {
+			return
+		}
+
+		sparklines.Lines[0].Data = ratesToInts(stats.RateHistory.InMsgsRate.Values())
+		sparklines.Lines[1].Data = ratesToInts(stats.RateHistory.OutMsgsRate.Values())
+	}
+
 	// Show empty values on first display
-	text := generateParagraph(engine, cleanStats)
+	text := render()
 	par := ui.NewPar(text)
 	par.Height = ui.TermHeight()
 	par.Width = ui.TermWidth()
@@ -290,14 +664,20 @@ func StartUI(
 	helpPar.Width = ui.TermWidth()
 	helpPar.HasBorder = false
 
-	// Top like view
-	paraRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, par))
+	// Top like view, with an optional sparklines panel above the
+	// connection table when -history is enabled.
+	var topViewGrid *ui.Grid
+	if historyEnabled {
+		sparkRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, sparklines))
+		paraRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, par))
+		topViewGrid = ui.NewGrid(sparkRow, paraRow)
+	} else {
+		paraRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, par))
+		topViewGrid = ui.NewGrid(paraRow)
+	}
 
 	// Help view
 	helpParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, helpPar))
-
-	// Create grids that we'll be using to toggle what to render
-	topViewGrid := ui.NewGrid(paraRow)
 	helpViewGrid := ui.NewGrid(helpParaRow)
 
 	// Start with the topviewGrid by default
@@ -310,13 +690,23 @@ func StartUI(
 	// Used for pinging the IU to refresh the screen with new values
 	redraw := make(chan struct{})
 
+	// forEachEngine applies a cluster-wide setting change (sort, limit,
+	// cid/state filter, subscriptions) to every server being monitored.
+	forEachEngine := func(fn func(*Engine)) {
+		for _, e := range cluster.Engines {
+			fn(e)
+		}
+	}
+
 	update := func() {
 		for {
-			stats := <-statsCh
+			serverStats := <-statsCh
+			statsMap[serverStats.Name] = serverStats.Stats
 
 			// Update top view text
-			text = generateParagraph(engine, stats)
+			text = render()
 			par.Text = text
+			refreshSparklines()
 
 			redraw <- struct{}{}
 		}
@@ -325,8 +715,14 @@ func StartUI(
 	// Flags for capturing options
 	waitingSortOption := false
 	waitingLimitOption := false
+	waitingCidOption := false
 	displaySubscriptions := false
 
+	// Cycles through the connection states that can be requested from
+	// the server when 't' is pressed.
+	connStates := []string{"open", "closed", "any"}
+	connStateIdx := 0
+
 	optionBuf := ""
 	refreshOptionHeader := func() {
 		// Need to mask what was typed before
@@ -355,8 +751,8 @@ func StartUI(
 
 					sortOpt := gnatsd.SortOpt(optionBuf)
 					switch sortOpt {
-					case SortByCid, SortBySubs, SortByPending, SortByOutMsgs, SortByInMsgs, SortByOutBytes, SortByInBytes:
-						engine.SortOpt = sortOpt
+					case SortByCid, SortBySubs, SortByPending, SortByOutMsgs, SortByInMsgs, SortByOutBytes, SortByInBytes, SortByMsgsRate:
+						forEachEngine(func(e *Engine) { e.SortOpt = sortOpt })
 					default:
 						go func() {
 							// Has to be at least of the same length as sort by header
@@ -383,7 +779,7 @@ func StartUI(
 				} else {
 					optionBuf += string(e.Ch)
 				}
-				fmt.Printf("\033[1;1H\033[6;1Hsort by [%s]: %s", engine.SortOpt, optionBuf)
+				fmt.Printf("\033[1;1H\033[6;1Hsort by [%s]: %s", focusedEngine().SortOpt, optionBuf)
 			}
 
 			if waitingLimitOption {
@@ -393,7 +789,7 @@ func StartUI(
 					var n int
 					_, err := fmt.Sscanf(optionBuf, "%d", &n)
 					if err == nil {
-						engine.Conns = n
+						forEachEngine(func(e *Engine) { e.Conns = n })
 					}
 
 					waitingLimitOption = false
@@ -409,7 +805,37 @@ func StartUI(
 				} else {
 					optionBuf += string(e.Ch)
 				}
-				fmt.Printf("\033[1;1H\033[6;1Hlimit   [%d]: %s", engine.Conns, optionBuf)
+				fmt.Printf("\033[1;1H\033[6;1Hlimit   [%d]: %s", focusedEngine().Conns, optionBuf)
+			}
+
+			if waitingCidOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+
+					if optionBuf == "" {
+						forEachEngine(func(e *Engine) { e.Cid = 0 })
+					} else {
+						var cid uint64
+						_, err := fmt.Sscanf(optionBuf, "%d", &cid)
+						if err == nil {
+							forEachEngine(func(e *Engine) { e.Cid = cid })
+						}
+					}
+
+					waitingCidOption = false
+					optionBuf = ""
+					refreshOptionHeader()
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshOptionHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				fmt.Printf("\033[1;1H\033[6;1Hcid     [%d]: %s", focusedEngine().Cid, optionBuf)
 			}
 
 			if e.Type == ui.EventKey && (e.Ch == 'q' || e.Key == ui.KeyCtrlC) {
@@ -417,14 +843,47 @@ func StartUI(
 				cleanExit()
 			}
 
-			if e.Type == ui.EventKey && e.Ch == 's' && !(waitingLimitOption || waitingSortOption) {
-				if displaySubscriptions {
-					displaySubscriptions = false
-					engine.DisplaySubs = false
+			if e.Type == ui.EventKey && e.Ch == 's' && !(waitingLimitOption || waitingSortOption || waitingCidOption) {
+				displaySubscriptions = !displaySubscriptions
+				forEachEngine(func(e *Engine) { e.DisplaySubs = displaySubscriptions })
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 't' && !(waitingLimitOption || waitingSortOption || waitingCidOption) && viewMode == TopViewMode {
+				connStateIdx = (connStateIdx + 1) % len(connStates)
+				forEachEngine(func(e *Engine) { e.State = connStates[connStateIdx] })
+			}
+
+			if e.Type == ui.EventKey && player != nil && e.Key == ui.KeySpace && !(waitingLimitOption || waitingSortOption || waitingCidOption) && viewMode == TopViewMode {
+				player.TogglePause()
+			}
+
+			if e.Type == ui.EventKey && player != nil && e.Ch == '.' && !(waitingLimitOption || waitingSortOption || waitingCidOption) && viewMode == TopViewMode {
+				if player.IsPaused() {
+					select {
+					case stepCh <- struct{}{}:
+					default:
+					}
+				}
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'a' && !(waitingLimitOption || waitingSortOption || waitingCidOption) && viewMode == TopViewMode {
+				aggregate = !aggregate
+				text = render()
+				par.Text = text
+				refreshSparklines()
+				go func() { redraw <- struct{}{} }()
+			}
+
+			if e.Type == ui.EventKey && (e.Ch == '[' || e.Ch == ']') && !(waitingLimitOption || waitingSortOption || waitingCidOption) && viewMode == TopViewMode && len(cluster.Names) > 0 {
+				if e.Ch == '[' {
+					focus = (focus - 1 + len(cluster.Names)) % len(cluster.Names)
 				} else {
-					displaySubscriptions = true
-					engine.DisplaySubs = true
+					focus = (focus + 1) % len(cluster.Names)
 				}
+				text = render()
+				par.Text = text
+				refreshSparklines()
+				go func() { redraw <- struct{}{} }()
 			}
 
 			if e.Type == ui.EventKey && viewMode == HelpViewMode {
@@ -433,17 +892,22 @@ func StartUI(
 				continue
 			}
 
-			if e.Type == ui.EventKey && e.Ch == 'o' && !waitingLimitOption && viewMode == TopViewMode {
-				fmt.Printf("\033[1;1H\033[6;1Hsort by [%s]:", engine.SortOpt)
+			if e.Type == ui.EventKey && e.Ch == 'o' && !(waitingLimitOption || waitingCidOption) && viewMode == TopViewMode {
+				fmt.Printf("\033[1;1H\033[6;1Hsort by [%s]:", focusedEngine().SortOpt)
 				waitingSortOption = true
 			}
 
-			if e.Type == ui.EventKey && e.Ch == 'n' && !waitingSortOption && viewMode == TopViewMode {
-				fmt.Printf("\033[1;1H\033[6;1Hlimit   [%d]:", engine.Conns)
+			if e.Type == ui.EventKey && e.Ch == 'n' && !(waitingSortOption || waitingCidOption) && viewMode == TopViewMode {
+				fmt.Printf("\033[1;1H\033[6;1Hlimit   [%d]:", focusedEngine().Conns)
 				waitingLimitOption = true
 			}
 
-			if e.Type == ui.EventKey && (e.Ch == '?' || e.Ch == 'h') && !(waitingSortOption || waitingLimitOption) {
+			if e.Type == ui.EventKey && e.Ch == 'c' && !(waitingSortOption || waitingLimitOption) && viewMode == TopViewMode {
+				fmt.Printf("\033[1;1H\033[6;1Hcid     [%d]:", focusedEngine().Cid)
+				waitingCidOption = true
+			}
+
+			if e.Type == ui.EventKey && (e.Ch == '?' || e.Ch == 'h') && !(waitingSortOption || waitingLimitOption || waitingCidOption) {
 				if viewMode == TopViewMode {
 					refreshOptionHeader()
 					optionBuf = ""
@@ -453,6 +917,7 @@ func StartUI(
 				viewMode = HelpViewMode
 				waitingLimitOption = false
 				waitingSortOption = false
+				waitingCidOption = false
 			}
 
 			if e.Type == ui.EventResize {
@@ -467,6 +932,16 @@ func StartUI(
 	}
 }
 
+// ratesToInts converts a slice of rate samples into the []int shape the
+// termui Sparkline widget expects.
+func ratesToInts(rates []float64) []int {
+	out := make([]int, len(rates))
+	for i, r := range rates {
+		out[i] = int(r + 0.5)
+	}
+	return out
+}
+
 func generateHelp() string {
 	text := `
 Command          Description
@@ -474,7 +949,7 @@ Command          Description
 o<option>        Set primary sort key to <option>.
 
                  Option can be one of: {cid|subs|pending|msgs_to|msgs_from|
-                 bytes_to, bytes_from}
+                 bytes_to|bytes_from|msgs_rate}
 
                  This can be set in the command line too with -sort flag.
 
@@ -487,8 +962,36 @@ n<limit>         Set sample size of connections to request from the server.
 
 s                Toggle displaying connection subscriptions.
 
+c<cid>           Filter connections to a single CID. Leave <cid> empty
+                 to clear the filter.
+
+                 This can be set in the command line too with -cid flag.
+
+t                Cycle the connection state filter: open, closed, any.
+
+                 This can be set in the command line as well via -state flag.
+
+[ ]              Cycle focus between servers when monitoring a cluster
+                 (-s host1:8222,host2:8222,...).
+
+a                Toggle between the per-server connection view and an
+                 aggregated cluster summary with per-server subtotals.
+
+                 When -history is set, a sparklines panel showing recent
+                 msgs/sec history is shown above the connection table.
+                 Sort by msgs_rate to find the loudest client recently,
+                 rather than over its lifetime.
+
+space            Pause/resume playback, when replaying with -replay.
+
+.                Step forward one sample while playback is paused.
+
 q                Quit nats-top.
 
+Run with -batch <n> to skip this interactive view entirely: nats-top
+polls n samples and writes them to stdout as -format table|csv|json,
+then exits, for scripting from cron/ansible or piping into awk/jq.
+
 Press any key to continue...
 
 `
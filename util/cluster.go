@@ -0,0 +1,46 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+// ServerStats tags a Stats sample with the server it came from, so a
+// Cluster can fan results from several Engines into a single channel.
+type ServerStats struct {
+	Name  string
+	Stats *Stats
+}
+
+// Cluster runs one Engine per monitored server and merges their polling
+// into a single stream of ServerStats.
+type Cluster struct {
+	Engines []*Engine
+
+	// Names holds the display name for each entry in Engines, in the
+	// same order, so callers can present a stable ordering of servers.
+	Names []string
+}
+
+// MonitorStats starts one MonitorStats goroutine per Engine in the
+// cluster and forwards every sample, tagged with its server name, on
+// statsCh until shutdownCh is closed.
+func (c *Cluster) MonitorStats(statsCh chan *ServerStats, shutdownCh chan struct{}) {
+	for i, engine := range c.Engines {
+		name := c.Names[i]
+
+		go func(engine *Engine, name string) {
+			ch := make(chan *Stats)
+			go engine.MonitorStats(ch, shutdownCh)
+
+			for {
+				select {
+				case stats := <-ch:
+					select {
+					case statsCh <- &ServerStats{Name: name, Stats: stats}:
+					case <-shutdownCh:
+						return
+					}
+				case <-shutdownCh:
+					return
+				}
+			}
+		}(engine, name)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// Engine holds the configuration and HTTP client used to poll a single
+// gnatsd monitoring endpoint.
+type Engine struct {
+	Uri         string
+	Conns       int
+	Delay       int
+	HttpClient  *http.Client
+	SortOpt     gnatsd.SortOpt
+	DisplaySubs bool
+
+	// Cid, when non-zero, restricts /connz to a single connection id.
+	Cid uint64
+
+	// State restricts /connz to connections in a given state
+	// ("open", "closed" or "any"). Empty means the server default.
+	State string
+
+	// History is the number of samples retained in RateHistory, for the
+	// sparklines drawn above the connection table. Zero disables it.
+	History int
+
+	LastStats *Stats
+
+	rateHistory *RateHistorySet
+	lastConns   map[uint64]*gnatsd.ConnInfo
+}
+
+// Rates captures the per-second deltas computed between the previous and
+// current poll of /varz.
+type Rates struct {
+	InMsgsRate   float64
+	OutMsgsRate  float64
+	InBytesRate  float64
+	OutBytesRate float64
+}
+
+// Stats is a single sample taken from a gnatsd monitoring endpoint.
+type Stats struct {
+	Varz  *gnatsd.Varz
+	Connz *gnatsd.Connz
+	Rates *Rates
+	Error error
+
+	// RateHistory holds the ring buffers of recent Rates samples. It is
+	// shared and mutated in place across polls, so it survives sort and
+	// limit changes rather than being reset alongside Connz.
+	RateHistory *RateHistorySet
+
+	// ConnRates holds the msgs/sec delta computed for each connection
+	// (keyed by Cid) between this poll and the last one.
+	ConnRates map[uint64]*ConnRate
+}
+
+// MonitorStats periodically polls /varz and /connz on the configured
+// endpoint and sends a *Stats on statsCh until shutdownCh is closed.
+func (engine *Engine) MonitorStats(statsCh chan *Stats, shutdownCh chan struct{}) {
+	delay := time.Duration(engine.Delay) * time.Second
+
+	for {
+		select {
+		case <-shutdownCh:
+			return
+		default:
+		}
+
+		stats, err := engine.fetchStats()
+		if err != nil {
+			statsCh <- &Stats{Error: err}
+		} else {
+			if engine.LastStats != nil {
+				stats.Rates = calculateRates(engine.LastStats, stats, delay)
+			} else {
+				stats.Rates = &Rates{}
+			}
+
+			if engine.History > 0 {
+				if engine.rateHistory == nil {
+					engine.rateHistory = NewRateHistorySet(engine.History)
+				}
+				engine.rateHistory.push(stats.Rates)
+				stats.RateHistory = engine.rateHistory
+			}
+
+			stats.ConnRates = calculateConnRates(engine.lastConns, stats.Connz.Conns, delay.Seconds())
+			engine.lastConns = connsByCid(stats.Connz.Conns)
+
+			engine.LastStats = stats
+			statsCh <- stats
+		}
+
+		select {
+		case <-shutdownCh:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (engine *Engine) fetchStats() (*Stats, error) {
+	varz := &gnatsd.Varz{}
+	if err := engine.getJson("/varz", varz); err != nil {
+		return nil, err
+	}
+
+	connz := &gnatsd.Connz{}
+	if err := engine.getJson(engine.connzPath(), connz); err != nil {
+		return nil, err
+	}
+
+	return &Stats{Varz: varz, Connz: connz}, nil
+}
+
+// connzPath builds the /connz query string, including the optional cid
+// and state filters and asking the server to compute subscriptions
+// whenever they're going to be displayed.
+func (engine *Engine) connzPath() string {
+	// SortByMsgsRate is a client-side-only sort computed from ConnRates;
+	// gnatsd doesn't recognize it as a sort= value and rejects the
+	// request, so fall back to the server's default ordering and let
+	// generateParagraph's sort.Sort(ByMsgsRate{...}) do the real work.
+	serverSort := engine.SortOpt
+	if serverSort == SortByMsgsRate {
+		serverSort = SortByCid
+	}
+
+	path := fmt.Sprintf("/connz?limit=%d&sort=%s", engine.Conns, serverSort)
+
+	if engine.Cid > 0 {
+		path += fmt.Sprintf("&cid=%d", engine.Cid)
+	}
+
+	if engine.State != "" {
+		path += fmt.Sprintf("&state=%s", engine.State)
+	}
+
+	if engine.DisplaySubs {
+		path += "&subs=1"
+	}
+
+	return path
+}
+
+func (engine *Engine) getJson(path string, v interface{}) error {
+	resp, err := engine.HttpClient.Get(engine.Uri + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get stats from server: %v", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func calculateRates(last *Stats, cur *Stats, delay time.Duration) *Rates {
+	secs := delay.Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+
+	return &Rates{
+		InMsgsRate:   float64(cur.Varz.InMsgs-last.Varz.InMsgs) / secs,
+		OutMsgsRate:  float64(cur.Varz.OutMsgs-last.Varz.OutMsgs) / secs,
+		InBytesRate:  float64(cur.Varz.InBytes-last.Varz.InBytes) / secs,
+		OutBytesRate: float64(cur.Varz.OutBytes-last.Varz.OutBytes) / secs,
+	}
+}
+
+func connsByCid(conns []*gnatsd.ConnInfo) map[uint64]*gnatsd.ConnInfo {
+	byCid := make(map[uint64]*gnatsd.ConnInfo, len(conns))
+	for _, conn := range conns {
+		byCid[conn.Cid] = conn
+	}
+	return byCid
+}
+
+func calculateConnRates(last map[uint64]*gnatsd.ConnInfo, conns []*gnatsd.ConnInfo, secs float64) map[uint64]*ConnRate {
+	if secs <= 0 {
+		secs = 1
+	}
+
+	rates := make(map[uint64]*ConnRate, len(conns))
+	for _, conn := range conns {
+		prev, ok := last[conn.Cid]
+		if !ok {
+			rates[conn.Cid] = &ConnRate{}
+			continue
+		}
+
+		rates[conn.Cid] = &ConnRate{
+			InMsgsRate:  float64(conn.InMsgs-prev.InMsgs) / secs,
+			OutMsgsRate: float64(conn.OutMsgs-prev.OutMsgs) / secs,
+		}
+	}
+
+	return rates
+}
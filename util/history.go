@@ -0,0 +1,79 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+// RateHistory is a fixed-size ring buffer of recent rate samples, used
+// to render the sparklines shown above the connection table. A zero
+// value RateHistory is not usable; use NewRateHistory.
+type RateHistory struct {
+	samples []float64
+	pos     int
+	full    bool
+}
+
+// NewRateHistory returns a RateHistory that retains the last size
+// samples pushed into it.
+func NewRateHistory(size int) *RateHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &RateHistory{samples: make([]float64, size)}
+}
+
+// Push records the latest sample, evicting the oldest one once the
+// buffer is full.
+func (h *RateHistory) Push(v float64) {
+	h.samples[h.pos] = v
+	h.pos = (h.pos + 1) % len(h.samples)
+	if h.pos == 0 {
+		h.full = true
+	}
+}
+
+// Values returns the retained samples in chronological order, oldest
+// first.
+func (h *RateHistory) Values() []float64 {
+	if !h.full {
+		out := make([]float64, h.pos)
+		copy(out, h.samples[:h.pos])
+		return out
+	}
+
+	out := make([]float64, 0, len(h.samples))
+	out = append(out, h.samples[h.pos:]...)
+	out = append(out, h.samples[:h.pos]...)
+	return out
+}
+
+// RateHistorySet groups the ring buffers for each of the server-wide
+// rates tracked alongside a Stats sample.
+type RateHistorySet struct {
+	InMsgsRate   *RateHistory
+	OutMsgsRate  *RateHistory
+	InBytesRate  *RateHistory
+	OutBytesRate *RateHistory
+}
+
+// NewRateHistorySet allocates a RateHistorySet whose ring buffers each
+// retain the last size samples.
+func NewRateHistorySet(size int) *RateHistorySet {
+	return &RateHistorySet{
+		InMsgsRate:   NewRateHistory(size),
+		OutMsgsRate:  NewRateHistory(size),
+		InBytesRate:  NewRateHistory(size),
+		OutBytesRate: NewRateHistory(size),
+	}
+}
+
+func (rh *RateHistorySet) push(rates *Rates) {
+	rh.InMsgsRate.Push(rates.InMsgsRate)
+	rh.OutMsgsRate.Push(rates.OutMsgsRate)
+	rh.InBytesRate.Push(rates.InBytesRate)
+	rh.OutBytesRate.Push(rates.OutBytesRate)
+}
+
+// ConnRate holds the msgs/sec deltas computed for a single connection
+// between the previous and current poll.
+type ConnRate struct {
+	InMsgsRate  float64
+	OutMsgsRate float64
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// RecordStats writes every ServerStats received from statsCh to w as
+// newline-delimited JSON, one sample per line, until shutdownCh is
+// closed. It's meant to be run in its own goroutine, fed from the same
+// stream of samples that's being rendered, so a production incident can
+// be captured once with -record and replayed later with -replay.
+func RecordStats(w io.Writer, statsCh chan *ServerStats, shutdownCh chan struct{}) error {
+	enc := json.NewEncoder(w)
+
+	for {
+		select {
+		case stats := <-statsCh:
+			if err := enc.Encode(stats); err != nil {
+				return err
+			}
+		case <-shutdownCh:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// ReplayOptions controls how a recorded snapshot file is played back.
+type ReplayOptions struct {
+	// Speed scales the delay between samples; 2.0 plays back twice as
+	// fast as it was recorded, 0.5 half as fast. Zero or negative means
+	// samples are delivered as fast as they can be decoded.
+	Speed float64
+
+	// Since and Until, when non-zero, drop samples outside this time
+	// range, based on each sample's Varz.Now.
+	Since time.Time
+	Until time.Time
+}
+
+// Player tracks whether a replay is currently paused, so the UI can
+// toggle and query it from a keybinding. resumeCh is created lazily by
+// waitForTurn the first time it has to block, and is closed by
+// TogglePause when it unpauses, so a goroutine already blocked there
+// wakes up immediately instead of waiting for the next step.
+type Player struct {
+	mu       sync.Mutex
+	paused   bool
+	resumeCh chan struct{}
+}
+
+// TogglePause flips the paused state and returns the new value. If this
+// unpauses the player, any waitForTurn call currently blocked waiting
+// to resume is woken up.
+func (p *Player) TogglePause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused = !p.paused
+	if !p.paused && p.resumeCh != nil {
+		close(p.resumeCh)
+		p.resumeCh = nil
+	}
+
+	return p.paused
+}
+
+// IsPaused reports whether the replay is currently paused.
+func (p *Player) IsPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// waitForTurn blocks while the player is paused, until a step is
+// requested on stepCh, the player is unpaused via TogglePause, or
+// shutdownCh closes. It returns false if the replay should stop.
+func (p *Player) waitForTurn(stepCh chan struct{}, shutdownCh chan struct{}) bool {
+	for {
+		p.mu.Lock()
+		if !p.paused {
+			p.mu.Unlock()
+			return true
+		}
+		if p.resumeCh == nil {
+			p.resumeCh = make(chan struct{})
+		}
+		resumeCh := p.resumeCh
+		p.mu.Unlock()
+
+		select {
+		case <-stepCh:
+			return true
+		case <-resumeCh:
+			// Unpaused; loop around to recheck in case it was
+			// immediately paused again.
+		case <-shutdownCh:
+			return false
+		}
+	}
+}
+
+// ReplayStats reads a file previously written by RecordStats and feeds
+// its samples into statsCh instead of polling a live server, honoring
+// opts.Since/opts.Until and opts.Speed. player and stepCh drive the
+// pause (space) and step (.) keybindings.
+func ReplayStats(r io.Reader, opts ReplayOptions, statsCh chan *ServerStats, shutdownCh chan struct{}, player *Player, stepCh chan struct{}) error {
+	dec := json.NewDecoder(r)
+
+	var lastTime time.Time
+	haveLastTime := false
+
+	for {
+		var sample ServerStats
+		if err := dec.Decode(&sample); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if sample.Stats == nil || sample.Stats.Varz == nil {
+			continue
+		}
+
+		ts := sample.Stats.Varz.Now
+		if !opts.Since.IsZero() && ts.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && ts.After(opts.Until) {
+			return nil
+		}
+
+		if haveLastTime && opts.Speed > 0 {
+			gap := time.Duration(float64(ts.Sub(lastTime)) / opts.Speed)
+			if gap > 0 {
+				select {
+				case <-time.After(gap):
+				case <-shutdownCh:
+					return nil
+				}
+			}
+		}
+		lastTime = ts
+		haveLastTime = true
+
+		if !player.waitForTurn(stepCh, shutdownCh) {
+			return nil
+		}
+
+		select {
+		case statsCh <- &sample:
+		case <-shutdownCh:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+import (
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// Sort options for the connections table. All but SortByMsgsRate are
+// sort= values that gnatsd understands on the server side; SortByMsgsRate
+// is computed client-side from ConnRates.
+const (
+	SortByCid      = gnatsd.SortOpt("cid")
+	SortBySubs     = gnatsd.SortOpt("subs")
+	SortByPending  = gnatsd.SortOpt("pending")
+	SortByOutMsgs  = gnatsd.SortOpt("msgs_to")
+	SortByInMsgs   = gnatsd.SortOpt("msgs_from")
+	SortByOutBytes = gnatsd.SortOpt("bytes_to")
+	SortByInBytes  = gnatsd.SortOpt("bytes_from")
+	SortByMsgsRate = gnatsd.SortOpt("msgs_rate")
+)
+
+type ByCid []*gnatsd.ConnInfo
+
+func (p ByCid) Len() int           { return len(p) }
+func (p ByCid) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByCid) Less(i, j int) bool { return p[i].Cid < p[j].Cid }
+
+type BySubs []*gnatsd.ConnInfo
+
+func (p BySubs) Len() int           { return len(p) }
+func (p BySubs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p BySubs) Less(i, j int) bool { return p[i].NumSubs < p[j].NumSubs }
+
+type ByPending []*gnatsd.ConnInfo
+
+func (p ByPending) Len() int           { return len(p) }
+func (p ByPending) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByPending) Less(i, j int) bool { return p[i].Pending < p[j].Pending }
+
+type ByMsgsTo []*gnatsd.ConnInfo
+
+func (p ByMsgsTo) Len() int           { return len(p) }
+func (p ByMsgsTo) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByMsgsTo) Less(i, j int) bool { return p[i].OutMsgs < p[j].OutMsgs }
+
+type ByMsgsFrom []*gnatsd.ConnInfo
+
+func (p ByMsgsFrom) Len() int           { return len(p) }
+func (p ByMsgsFrom) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByMsgsFrom) Less(i, j int) bool { return p[i].InMsgs < p[j].InMsgs }
+
+type ByBytesTo []*gnatsd.ConnInfo
+
+func (p ByBytesTo) Len() int           { return len(p) }
+func (p ByBytesTo) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByBytesTo) Less(i, j int) bool { return p[i].OutBytes < p[j].OutBytes }
+
+type ByBytesFrom []*gnatsd.ConnInfo
+
+func (p ByBytesFrom) Len() int           { return len(p) }
+func (p ByBytesFrom) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByBytesFrom) Less(i, j int) bool { return p[i].InBytes < p[j].InBytes }
+
+// ByMsgsRate sorts connections by their combined in+out msgs/sec over
+// the last poll, using the ConnRates computed by Engine.MonitorStats.
+// Connections missing from Rates sort as having a rate of zero, the same
+// rate Engine.MonitorStats gives every connection on its first poll.
+type ByMsgsRate struct {
+	Conns []*gnatsd.ConnInfo
+	Rates map[uint64]*ConnRate
+}
+
+func (s ByMsgsRate) Len() int      { return len(s.Conns) }
+func (s ByMsgsRate) Swap(i, j int) { s.Conns[i], s.Conns[j] = s.Conns[j], s.Conns[i] }
+func (s ByMsgsRate) Less(i, j int) bool {
+	return s.rate(s.Conns[i].Cid) < s.rate(s.Conns[j].Cid)
+}
+
+func (s ByMsgsRate) rate(cid uint64) float64 {
+	r, ok := s.Rates[cid]
+	if !ok {
+		return 0
+	}
+	return r.InMsgsRate + r.OutMsgsRate
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2015 NATS Messaging System
+package util
+
+import "fmt"
+
+// Psize takes a raw byte count and returns a short, human readable
+// representation using K/M/G suffixes, similar to what top(1) shows.
+func Psize(s int64) string {
+	size := float64(s)
+
+	if size < 1024 {
+		return fmt.Sprintf("%d", s)
+	}
+
+	if size < (1024 * 1024) {
+		return fmt.Sprintf("%.1fK", size/1024)
+	}
+
+	if size < (1024 * 1024 * 1024) {
+		return fmt.Sprintf("%.1fM", size/1024/1024)
+	}
+
+	return fmt.Sprintf("%.1fG", size/1024/1024/1024)
+}